@@ -0,0 +1,65 @@
+// Copyright 2014 Wei Shen (shenwei356@gmail.com). All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+//
+// Group runs a set of functions against a shared ParallelMap,
+// propagating the first error back through Wait instead of exiting
+// the process, modeled on golang.org/x/sync/errgroup.
+package pmap
+
+import (
+	"context"
+	"sync"
+)
+
+// Group runs functions that all operate on the same ParallelMap. The
+// first function to return a non-nil error cancels the Group's
+// context; Wait returns that error once every function has returned.
+type Group struct {
+	// Map is the ParallelMap shared by every function passed to Go.
+	Map *ParallelMap
+
+	cancel context.CancelFunc
+
+	wg      sync.WaitGroup
+	errOnce sync.Once
+	err     error
+}
+
+// NewGroupWithContext returns a new Group backed by a fresh
+// ParallelMap, and a derived Context that is canceled as soon as one
+// of the Group's functions returns an error or ctx itself is done.
+func NewGroupWithContext(ctx context.Context) (*Group, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Group{Map: NewParallelMap(), cancel: cancel}, ctx
+}
+
+// Go runs f in a new goroutine, passing it the Group's ParallelMap.
+// The first call to f that returns a non-nil error cancels the
+// Group's context; that error is the one Wait returns.
+func (this *Group) Go(f func(*ParallelMap) error) {
+	this.wg.Add(1)
+
+	go func() {
+		defer this.wg.Done()
+
+		if err := f(this.Map); err != nil {
+			this.errOnce.Do(func() {
+				this.err = err
+				if this.cancel != nil {
+					this.cancel()
+				}
+			})
+		}
+	}()
+}
+
+// Wait blocks until every function started by Go has returned, then
+// returns the first non-nil error, if any.
+func (this *Group) Wait() error {
+	this.wg.Wait()
+	if this.cancel != nil {
+		this.cancel()
+	}
+	return this.err
+}
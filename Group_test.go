@@ -0,0 +1,76 @@
+// Copyright 2014 Wei Shen (shenwei356@gmail.com). All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+package pmap
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGroupPropagatesError(t *testing.T) {
+	g, ctx := NewGroupWithContext(context.Background())
+
+	wantErr := errors.New("boom")
+	g.Go(func(m *ParallelMap) error {
+		m.Set("a", 1)
+		return nil
+	})
+	g.Go(func(m *ParallelMap) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	g.Go(func(m *ParallelMap) error {
+		return wantErr
+	})
+
+	if err := g.Wait(); err != wantErr {
+		t.Errorf("Wait() = %v, want %v", err, wantErr)
+	}
+
+	if v, ok := g.Map.Get("a"); !ok || v.(int) != 1 {
+		t.Error("successful Go calls should still have run against the shared map")
+	}
+}
+
+func TestContextAwareOps(t *testing.T) {
+	m := NewParallelMap()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := m.SetContext(ctx, "a", 1); err != ctx.Err() {
+		t.Errorf("SetContext should return ctx.Err() once canceled, got %v", err)
+	}
+	if _, ok := m.Get("a"); ok {
+		t.Error("SetContext should not have stored a value after cancellation")
+	}
+
+	if _, _, err := m.GetContext(ctx, "a"); err != ctx.Err() {
+		t.Errorf("GetContext should return ctx.Err() once canceled, got %v", err)
+	}
+
+	if err := m.ExecuteFuncContext(ctx, func() error { return nil }); err != ctx.Err() {
+		t.Errorf("ExecuteFuncContext should return ctx.Err() once canceled, got %v", err)
+	}
+}
+
+// TestExecuteFuncContextRunsBody exercises ExecuteFuncContext's real
+// path, with a live (not already-canceled) context and a body that
+// actually does something, rather than only the early-return path.
+func TestExecuteFuncContextRunsBody(t *testing.T) {
+	m := NewParallelMap()
+
+	runWithTimeout(t, 3*time.Second, func() {
+		var ran bool
+		err := m.ExecuteFuncContext(context.Background(), func() error {
+			ran = true
+			return nil
+		})
+		if err != nil || !ran {
+			t.Error("ExecuteFuncContext should have run f and returned its result")
+		}
+	})
+}
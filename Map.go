@@ -0,0 +1,301 @@
+// Copyright 2014 Wei Shen (shenwei356@gmail.com). All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+//
+// Map is a generic, type-safe counterpart to ParallelMap; in fact,
+// ParallelMap is a thin wrapper around Map[interface{}, interface{}],
+// so the two types share all of their shard bookkeeping and never
+// drift out of sync with each other. Keys and values are typed here,
+// which removes the interface{} boxing and type assertions that
+// ParallelMap requires.
+//
+// Example:
+//
+//    m := pmap.NewMap[string, int]()
+//    m.SetUpdateValueFunc(func(oldValue, newValue int) int {
+//        return oldValue + newValue
+//    })
+//    m.Set("age", 26)
+//    m.Update("age", 1)
+//    age, ok := m.Get("age")
+//
+package pmap
+
+import "sync"
+
+// call represents an in-flight or completed GetOrCompute invocation
+// for a single key.
+type call[V any] struct {
+	wg    sync.WaitGroup
+	value V
+	err   error
+
+	// forgotten is set by Forget if it runs while this call is still
+	// in flight, telling GetOrCompute not to cache fn's result once it
+	// returns.
+	forgotten bool
+}
+
+// mapShard is one partition of a Map, guarded by its own lock.
+type mapShard[K comparable, V any] struct {
+	sync.RWMutex
+	m map[K]V
+
+	// calls tracks in-flight GetOrCompute calls, keyed the same way
+	// as m. It is created lazily since most shards never use
+	// GetOrCompute.
+	calls map[K]*call[V]
+}
+
+// Map is a sharded map safe for concurrent use by multiple goroutines,
+// with typed keys and values.
+type Map[K comparable, V any] struct {
+	shards    []*mapShard[K, V]
+	shardMask uint64
+
+	// HashFunc picks the shard a key belongs to. Defaults to hashing
+	// key the same way ParallelMap's defaultHashFunc does.
+	HashFunc func(key K) uint64
+
+	// function to update value
+	UpdateValueFunc func(oldValue, newValue V) V
+
+	// EqualFunc compares two stored values for CompareAndSwap and
+	// CompareAndDelete. Defaults to ==, which panics for uncomparable
+	// types; set it explicitly when values may not be comparable.
+	EqualFunc func(a, b V) bool
+}
+
+// NewMap creates a Map with K keys and V values.
+func NewMap[K comparable, V any]() *Map[K, V] {
+	this := new(Map[K, V])
+
+	n := shardCountForGOMAXPROCS()
+	this.shards = make([]*mapShard[K, V], n)
+	for i := range this.shards {
+		this.shards[i] = &mapShard[K, V]{m: make(map[K]V)}
+	}
+	this.shardMask = uint64(n - 1)
+
+	this.HashFunc = func(key K) uint64 {
+		return defaultHashFunc(key)
+	}
+
+	// by default, the Update function is equal to Set function.
+	this.UpdateValueFunc = func(oldValue, newValue V) V {
+		return newValue
+	}
+
+	// by default, values are compared with ==.
+	this.EqualFunc = func(a, b V) bool {
+		return any(a) == any(b)
+	}
+
+	return this
+}
+
+// shardFor returns the shard responsible for key.
+func (this *Map[K, V]) shardFor(key K) *mapShard[K, V] {
+	return this.shards[this.HashFunc(key)&this.shardMask]
+}
+
+// Get retrieves the value for key, taking only a read lock on the
+// shard key belongs to.
+func (this *Map[K, V]) Get(key K) (V, bool) {
+	s := this.shardFor(key)
+	s.RLock()
+	value, ok := s.m[key]
+	s.RUnlock()
+	return value, ok
+}
+
+// Set stores value for key, taking a write lock on just the shard
+// key belongs to.
+func (this *Map[K, V]) Set(key K, value V) {
+	s := this.shardFor(key)
+	s.Lock()
+	s.m[key] = value
+	s.Unlock()
+}
+
+// SetUpdateValueFunc sets the function used by Update to combine an
+// existing value with a new one. By default, Update behaves like Set.
+func (this *Map[K, V]) SetUpdateValueFunc(f func(oldValue, newValue V) V) {
+	this.UpdateValueFunc = f
+}
+
+// Update applies UpdateValueFunc to the existing value for key (or
+// just stores value if key is absent).
+func (this *Map[K, V]) Update(key K, value V) {
+	s := this.shardFor(key)
+	s.Lock()
+	oldValue, ok := s.m[key]
+	if ok {
+		s.m[key] = this.UpdateValueFunc(oldValue, value)
+	} else {
+		s.m[key] = value
+	}
+	s.Unlock()
+}
+
+// Delete removes key from the map.
+func (this *Map[K, V]) Delete(key K) {
+	s := this.shardFor(key)
+	s.Lock()
+	delete(s.m, key)
+	s.Unlock()
+}
+
+// Len returns the total number of elements across all shards.
+func (this *Map[K, V]) Len() int {
+	n := 0
+	for _, s := range this.shards {
+		s.RLock()
+		n += len(s.m)
+		s.RUnlock()
+	}
+	return n
+}
+
+// Range calls f sequentially for each key and value present in the
+// map. If f returns false, Range stops the iteration. Range snapshots
+// each shard under its read lock and calls f outside of that lock, so
+// a slow or blocking f does not hold up writers; as with sync.Map,
+// Range may or may not reflect concurrent modifications made during
+// the call.
+func (this *Map[K, V]) Range(f func(key K, value V) bool) {
+	for _, s := range this.shards {
+		s.RLock()
+		keys := make([]K, 0, len(s.m))
+		values := make([]V, 0, len(s.m))
+		for k, v := range s.m {
+			keys = append(keys, k)
+			values = append(values, v)
+		}
+		s.RUnlock()
+
+		for i := range keys {
+			if !f(keys[i], values[i]) {
+				return
+			}
+		}
+	}
+}
+
+// LoadOrStore returns the existing value for key if present.
+// Otherwise, it stores and returns value. The loaded result is true
+// if value was loaded, false if stored.
+func (this *Map[K, V]) LoadOrStore(key K, value V) (actual V, loaded bool) {
+	s := this.shardFor(key)
+	s.Lock()
+	defer s.Unlock()
+
+	if actual, loaded = s.m[key]; loaded {
+		return actual, true
+	}
+	s.m[key] = value
+	return value, false
+}
+
+// LoadAndDelete deletes key and returns its previous value, if any.
+func (this *Map[K, V]) LoadAndDelete(key K) (value V, loaded bool) {
+	s := this.shardFor(key)
+	s.Lock()
+	defer s.Unlock()
+
+	value, loaded = s.m[key]
+	if loaded {
+		delete(s.m, key)
+	}
+	return value, loaded
+}
+
+// CompareAndSwap stores new for key if the existing value equals old,
+// as decided by EqualFunc (which defaults to == and, like sync.Map,
+// panics if the values are not comparable). Set EqualFunc before
+// calling this with values that are not safe to compare with ==. It
+// reports whether the swap happened.
+func (this *Map[K, V]) CompareAndSwap(key K, old, new V) bool {
+	s := this.shardFor(key)
+	s.Lock()
+	defer s.Unlock()
+
+	current, ok := s.m[key]
+	if !ok || !this.EqualFunc(current, old) {
+		return false
+	}
+	s.m[key] = new
+	return true
+}
+
+// CompareAndDelete deletes key if its existing value equals old, as
+// decided by EqualFunc. It reports whether the delete happened.
+func (this *Map[K, V]) CompareAndDelete(key K, old V) bool {
+	s := this.shardFor(key)
+	s.Lock()
+	defer s.Unlock()
+
+	current, ok := s.m[key]
+	if !ok || !this.EqualFunc(current, old) {
+		return false
+	}
+	delete(s.m, key)
+	return true
+}
+
+// GetOrCompute returns the value stored for key. If the key is
+// missing, it calls fn to compute the value, stores the result (if
+// fn returned a nil error) and returns it. Concurrent GetOrCompute
+// calls for the same missing key share a single call to fn; shared
+// reports whether this caller received a result computed by another
+// goroutine's call rather than running fn itself.
+func (this *Map[K, V]) GetOrCompute(key K, fn func() (V, error)) (value V, err error, shared bool) {
+	s := this.shardFor(key)
+
+	s.Lock()
+	if v, ok := s.m[key]; ok {
+		s.Unlock()
+		return v, nil, false
+	}
+	if c, ok := s.calls[key]; ok {
+		s.Unlock()
+		c.wg.Wait()
+		return c.value, c.err, true
+	}
+
+	c := new(call[V])
+	c.wg.Add(1)
+	if s.calls == nil {
+		s.calls = make(map[K]*call[V])
+	}
+	s.calls[key] = c
+	s.Unlock()
+
+	value, err = fn()
+
+	s.Lock()
+	c.value, c.err = value, err
+	if err == nil && !c.forgotten {
+		s.m[key] = value
+	}
+	delete(s.calls, key)
+	s.Unlock()
+
+	c.wg.Done()
+	return value, err, false
+}
+
+// Forget tells the map to not keep key's in-flight call result, if
+// any, and removes any cached value for key, so the next GetOrCompute
+// call for key runs fn again instead of reusing a stale result. If a
+// GetOrCompute call for key is still running, it is marked so that it
+// will not repopulate the map with fn's result once it finishes.
+func (this *Map[K, V]) Forget(key K) {
+	s := this.shardFor(key)
+	s.Lock()
+	delete(s.m, key)
+	if c, ok := s.calls[key]; ok {
+		c.forgotten = true
+	}
+	s.Unlock()
+}
@@ -0,0 +1,160 @@
+// Copyright 2014 Wei Shen (shenwei356@gmail.com). All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+package pmap
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestMap(t *testing.T) {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+
+	// number of goroutines that will operate on Map
+	N := runtime.NumCPU() * 30
+
+	// constructor, instantiated with concrete key/value types
+	m := NewMap[string, int]()
+
+	m.SetUpdateValueFunc(func(oldValue, newValue int) int {
+		return oldValue + newValue
+	})
+
+	// number of distinct keys in the map
+	var n int = 26
+	keys := make([]string, n)
+	for j := 0; j < n; j++ {
+		keys[j] = string(rune('a' + j))
+	}
+
+	var wg sync.WaitGroup
+	for i := 1; i <= N; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < n; j++ {
+				m.Update(keys[j], 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if m.Len() != n {
+		t.Errorf("Len() = %d, want %d", m.Len(), n)
+	}
+
+	for _, key := range keys {
+		v, ok := m.Get(key)
+		if !ok {
+			t.Errorf("missing key: %s", key)
+		}
+		if v != N {
+			t.Errorf("value for %s = %d, want %d", key, v, N)
+		}
+	}
+}
+
+func TestMapSetGetDelete(t *testing.T) {
+	m := NewMap[string, int]()
+
+	if _, ok := m.Get("age"); ok {
+		t.Error("Get on a missing key should report !ok")
+	}
+
+	m.Set("age", 26)
+	if v, ok := m.Get("age"); !ok || v != 26 {
+		t.Error("Get should have returned the stored value")
+	}
+
+	m.Delete("age")
+	if _, ok := m.Get("age"); ok {
+		t.Error("Get after Delete should report !ok")
+	}
+}
+
+func TestMapAtomicOps(t *testing.T) {
+	m := NewMap[string, int]()
+
+	// LoadOrStore
+	actual, loaded := m.LoadOrStore("age", 26)
+	if loaded || actual != 26 {
+		t.Error("LoadOrStore should have stored a fresh value")
+	}
+	actual, loaded = m.LoadOrStore("age", 30)
+	if !loaded || actual != 26 {
+		t.Error("LoadOrStore should have loaded the existing value")
+	}
+
+	// CompareAndSwap
+	if !m.CompareAndSwap("age", 26, 27) {
+		t.Error("CompareAndSwap should have succeeded")
+	}
+	if m.CompareAndSwap("age", 26, 28) {
+		t.Error("CompareAndSwap should have failed on stale old value")
+	}
+
+	// CompareAndDelete
+	if m.CompareAndDelete("age", 26) {
+		t.Error("CompareAndDelete should have failed on stale old value")
+	}
+	if !m.CompareAndDelete("age", 27) {
+		t.Error("CompareAndDelete should have succeeded")
+	}
+	if _, ok := m.Get("age"); ok {
+		t.Error("age should have been deleted")
+	}
+
+	// LoadAndDelete
+	m.Set("name", 99)
+	value, loaded := m.LoadAndDelete("name")
+	if !loaded || value != 99 {
+		t.Error("LoadAndDelete should have returned the stored value")
+	}
+	if _, ok := m.Get("name"); ok {
+		t.Error("name should have been deleted")
+	}
+}
+
+func TestMapRange(t *testing.T) {
+	m := NewMap[string, int]()
+	m.Set("a", 1)
+	m.Set("b", 2)
+	m.Set("c", 3)
+
+	sum := 0
+	m.Range(func(key string, value int) bool {
+		sum += value
+		return true
+	})
+	if sum != 6 {
+		t.Errorf("Range visited values summing to %d, want 6", sum)
+	}
+}
+
+func TestMapGetOrCompute(t *testing.T) {
+	m := NewMap[string, int]()
+
+	value, err, shared := m.GetOrCompute("answer", func() (int, error) {
+		return 42, nil
+	})
+	if err != nil || value != 42 || shared {
+		t.Error("GetOrCompute should have computed and stored a fresh value")
+	}
+
+	value, err, shared = m.GetOrCompute("answer", func() (int, error) {
+		t.Fatal("fn should not run once the value is cached")
+		return 0, nil
+	})
+	if err != nil || value != 42 || shared {
+		t.Error("GetOrCompute should have returned the cached value without sharing")
+	}
+
+	m.Forget("answer")
+	if _, ok := m.Get("answer"); ok {
+		t.Error("Forget should have removed the cached value")
+	}
+}
@@ -4,9 +4,16 @@
 //
 // ParallelMap - A lock-free parallel map in go.
 //
-// ParallelMap uses a backend goroutine for the sequential excution of
-// functions including Get, Set, Update and custom function,
-// which was inspired by section 14.17 in book *The Way to Go*.
+// ParallelMap is a thin, interface{}-keyed wrapper around
+// Map[interface{}, interface{}]: Get, Set, Update and the rest of its
+// operations shard their data across a number of independent buckets,
+// each one guarded by its own sync.RWMutex, so operations on keys that
+// land in different shards can run concurrently instead of being
+// serialized through a single backend goroutine. All of that shard
+// bookkeeping lives in Map; ParallelMap adds the rest of its API on
+// top: ExecuteFunc and ExecuteFuncForKey, Stop, the context-aware
+// variants, the deprecated Map and Op fields, and the opt-in
+// worker-pool mode with TryAcquire and Release.
 //
 // Example:
 //
@@ -59,156 +66,279 @@
 //        m.Stop()
 //
 //        // do something else
-//        length := len(m.Map)
+//        length := m.Len()
 //        fmt.Printf("%d elements in map\n", length)
 //    }
 //
 package pmap
 
 import (
+	"context"
 	"fmt"
-	"os"
+	"hash/fnv"
+	"runtime"
 	"sync"
 )
 
-// ParallelMap
+// shardsPerCPU controls how many shards are created per GOMAXPROCS,
+// giving goroutines running on different cores room to hit different
+// shards instead of contending for the same lock.
+const shardsPerCPU = 8
+
+// shardCountForGOMAXPROCS returns the next power of two at or above
+// runtime.GOMAXPROCS(0)*shardsPerCPU, so shard lookups can use a
+// bitmask instead of a modulo.
+func shardCountForGOMAXPROCS() int {
+	n := runtime.GOMAXPROCS(0) * shardsPerCPU
+	shardCount := 1
+	for shardCount < n {
+		shardCount <<= 1
+	}
+	return shardCount
+}
+
+// defaultHashFunc hashes string and []byte with fnv-1a and falls back
+// to hashing the key's fmt.Sprintf("%v", key) representation for
+// every other type.
+func defaultHashFunc(key interface{}) uint64 {
+	h := fnv.New64a()
+	switch k := key.(type) {
+	case string:
+		h.Write([]byte(k))
+	case []byte:
+		h.Write(k)
+	default:
+		fmt.Fprintf(h, "%v", k)
+	}
+	return h.Sum64()
+}
+
+// HashFunc assigns a key to a shard. A ParallelMap created with
+// NewParallelMap uses an fnv-based HashFunc that handles string and []byte keys
+// directly and falls back to hashing fmt.Sprintf("%v", key) for
+// anything else; set HashFunc before concurrent use if keys need
+// custom hashing.
+type HashFunc func(key interface{}) uint64
+
+// EqualFunc compares two values stored in a ParallelMap for
+// CompareAndSwap and CompareAndDelete. It is kept as a named type,
+// like HashFunc above, for code that declares variables of type
+// pmap.EqualFunc; the promoted EqualFunc field on ParallelMap (from
+// Map[interface{}, interface{}]) accepts any value of this type.
+type EqualFunc func(a, b interface{}) bool
+
+// parallelMapBackend is Map[interface{}, interface{}], given its own
+// name so that ParallelMap can embed it under that name instead of
+// under the promoted name "Map" — keeping "Map" free for the
+// deprecated, pre-generics field below.
+type parallelMapBackend = Map[interface{}, interface{}]
+
+// ParallelMap is a sharded map safe for concurrent use by multiple
+// goroutines without the caller doing any extra locking. It embeds
+// parallelMapBackend (a Map[interface{}, interface{}]), which promotes
+// Delete, Len, Range, LoadOrStore, LoadAndDelete, CompareAndSwap,
+// CompareAndDelete, GetOrCompute, Forget, SetUpdateValueFunc,
+// HashFunc, UpdateValueFunc and EqualFunc. Get, Set and Update are
+// defined on ParallelMap itself, wrapping the backend's methods with
+// the worker-pool gating.
 type ParallelMap struct {
-	// map
+	*parallelMapBackend
+
+	// Map is deprecated: ParallelMap used to store its entries
+	// directly in this field; it is now always empty. Use Get, Range
+	// or Len instead.
 	Map map[interface{}]interface{}
 
-	// backend goroutine for sequential operations
+	// Op is deprecated and no longer used internally; it is kept
+	// only so that code referencing it still compiles.
 	Op chan func() error
-	// waitgroup for operations
+
+	// waitgroup that Stop waits on; no operation adds to it
 	wg sync.WaitGroup
 
-	// function to update value
-	UpdateValueFunc func(interface{}, interface{}) interface{}
+	// sem, when non-nil, gates every Get/Set/Update behind a weighted
+	// semaphore of size workers, set up by NewParallelMapWithWorkers.
+	// Reads acquire 1 unit; writes acquire workers units so they run
+	// exclusively of every other Get, Set or Update.
+	sem     *Semaphore
+	workers int64
 }
 
 // Constructor of ParallelMap
 func NewParallelMap() *ParallelMap {
 	this := new(ParallelMap)
+	this.parallelMapBackend = NewMap[interface{}, interface{}]()
 	this.Map = make(map[interface{}]interface{})
 	this.Op = make(chan func() error)
+	return this
+}
 
-	// by default, the Update function is equal to Set function.
-	this.UpdateValueFunc = func(oldValue interface{}, newValue interface{}) interface{} {
-		return newValue
-	}
-
-	go this.backend()
+// NewParallelMapWithWorkers returns a ParallelMap whose Get, Set and
+// Update are additionally gated by a weighted semaphore sized for n
+// concurrent workers: a Get acquires 1 unit so up to n reads run at
+// once, while a Set or Update acquires all n units so it runs
+// exclusively of every other Get, Set or Update. Use this mode to
+// bound how much concurrent work the map admits, on top of the
+// per-shard locking every ParallelMap already does. The other
+// operations (Delete, Range, Len, LoadOrStore, LoadAndDelete,
+// CompareAndSwap, CompareAndDelete, GetOrCompute, Forget, ExecuteFunc
+// and ExecuteFuncForKey) do not acquire the semaphore.
+func NewParallelMapWithWorkers(n int) *ParallelMap {
+	this := NewParallelMap()
+	this.workers = int64(n)
+	this.sem = NewSemaphore(int64(n))
 	return this
 }
 
-// Run operation channel as backend
-func (this *ParallelMap) backend() {
-	var f func() error
-	var err error
-	for {
-		select {
-		case f = <-this.Op:
-			err = f()
-			if err != nil {
-				fmt.Fprintln(os.Stderr, err.Error())
-				os.Exit(1)
-			}
-		}
+// acquireRead blocks, if running in worker-pool mode, until 1 unit of
+// the map's semaphore is free.
+func (this *ParallelMap) acquireRead() {
+	if this.sem != nil {
+		this.sem.Acquire(context.Background(), 1)
 	}
 }
 
-// Stop the map backend
-func (this *ParallelMap) Stop() {
-	this.wg.Wait()
+func (this *ParallelMap) releaseRead() {
+	if this.sem != nil {
+		this.sem.Release(1)
+	}
 }
 
-// Getting element of the map is executed sequentially
-func (this *ParallelMap) Get(key interface{}) (interface{}, bool) {
-	this.wg.Add(1)
+// acquireWrite blocks, if running in worker-pool mode, until every
+// unit of the map's semaphore is free.
+func (this *ParallelMap) acquireWrite() {
+	if this.sem != nil {
+		this.sem.Acquire(context.Background(), this.workers)
+	}
+}
 
-	c1 := make(chan interface{})
-	c2 := make(chan bool)
-	this.Op <- func() error {
-		value, ok := this.Map[key]
+func (this *ParallelMap) releaseWrite() {
+	if this.sem != nil {
+		this.sem.Release(this.workers)
+	}
+}
 
-		c1 <- value
-		c2 <- ok
-		this.wg.Done()
-		return nil
+// TryAcquire attempts to acquire cost units of the map's semaphore
+// without blocking, reporting whether it succeeded. It is a no-op
+// that always succeeds unless the map was created with
+// NewParallelMapWithWorkers. Callers can use it, together with
+// Release, to implement their own admission control in front of
+// operations such as ExecuteFunc.
+func (this *ParallelMap) TryAcquire(cost int64) bool {
+	if this.sem == nil {
+		return true
 	}
-	return <-c1, <-c2
+	return this.sem.TryAcquire(cost)
 }
 
-// Setting operation is executed sequentially to ensure the
-// operation is atomic.
-func (this *ParallelMap) Set(key interface{}, value interface{}) {
-	this.wg.Add(1)
+// Release returns cost units acquired via TryAcquire to the map's
+// semaphore. It is a no-op
+// unless the map was created with NewParallelMapWithWorkers.
+func (this *ParallelMap) Release(cost int64) {
+	if this.sem != nil {
+		this.sem.Release(cost)
+	}
+}
 
-	c := make(chan bool)
-	this.Op <- func() error {
-		this.Map[key] = value
+// Stop returns right away. Every operation on the sharded map
+// completes synchronously and none is tracked, so Stop does not wait
+// for calls still running in other goroutines; it is kept for API
+// compatibility with earlier versions built around a single backend
+// goroutine.
+func (this *ParallelMap) Stop() {
+	this.wg.Wait()
+}
 
-		c <- true
-		this.wg.Done()
-		return nil
-	}
-	<-c
+// Getting element of the map only takes a read lock on the shard key
+// belongs to. In worker-pool mode (see NewParallelMapWithWorkers) it
+// first acquires 1 unit of the map's semaphore.
+func (this *ParallelMap) Get(key interface{}) (interface{}, bool) {
+	this.acquireRead()
+	defer this.releaseRead()
+	return this.parallelMapBackend.Get(key)
 }
 
-// To use Update function, a custom UpdateValueFunc must be set.
-// By default, the Update function is equal to Set function.
-//
-// The default UpdateValueFunc is:
-//
-//    this.UpdateValueFunc = func(oldValue interface{}, newValue interface{}) interface{} {
-//        return newValue
-//    }
-func (this *ParallelMap) SetUpdateValueFunc(f func(interface{}, interface{}) interface{}) {
-	this.UpdateValueFunc = f
+// Setting operation takes a write lock on just the shard key belongs
+// to, so it is atomic with respect to other operations on the same
+// key. In worker-pool mode (see NewParallelMapWithWorkers) it first
+// acquires every unit of the map's semaphore, so it runs exclusively
+// of all other Get, Set and Update calls.
+func (this *ParallelMap) Set(key interface{}, value interface{}) {
+	this.acquireWrite()
+	defer this.releaseWrite()
+	this.parallelMapBackend.Set(key, value)
 }
 
 // Update function.
-// To use Update function, a custom UpdateValueFunc must be set.
+// Until a custom UpdateValueFunc is set (see SetUpdateValueFunc),
+// Update behaves like Set. In worker-pool mode (see
+// NewParallelMapWithWorkers) it first acquires every unit of the
+// map's semaphore, so it runs exclusively of all other Get, Set and
+// Update calls.
 func (this *ParallelMap) Update(key interface{}, value interface{}) {
-	this.wg.Add(1)
-
-	c := make(chan bool)
-	this.Op <- func() error {
-		oldValue, ok := this.Map[key]
-		if ok {
-			this.Map[key] = this.UpdateValueFunc(oldValue, value)
-		} else {
-			this.Map[key] = value
-		}
+	this.acquireWrite()
+	defer this.releaseWrite()
+	this.parallelMapBackend.Update(key, value)
+}
 
-		c <- true
-		this.wg.Done()
-		return nil
+// Execute a custom function while holding a write lock on every
+// shard, returning whatever error f returns. Because this locks the
+// whole map it should be used sparingly, for operations that
+// genuinely need exclusivity against every other operation.
+//
+// f must not call Get, Set, Update, or any other ParallelMap method:
+// those take the same shard locks ExecuteFunc is already holding, and
+// sync.RWMutex is not reentrant, so calling back in deadlocks. Use
+// ExecuteFuncForKey if f needs to read or write a single key; it
+// holds the write lock on that key's shard only.
+//
+// Example: run something external that must not overlap with any
+// concurrent map access
+//
+//    err := m.ExecuteFunc(func() error {
+//        return flushToDisk(snapshotID)
+//    })
+func (this *ParallelMap) ExecuteFunc(f func() error) error {
+	for _, s := range this.parallelMapBackend.shards {
+		s.Lock()
 	}
-	<-c
+	defer func() {
+		for _, s := range this.parallelMapBackend.shards {
+			s.Unlock()
+		}
+	}()
+
+	return f()
 }
 
-// Execute a custom function.
+// ExecuteFuncForKey runs f while holding the write lock on the single
+// shard key belongs to, passing f lock-free get/set closures scoped
+// to that key. Unlike ExecuteFunc, f may use get/set freely; it must
+// still not call Get, Set, Update, or any other ParallelMap method,
+// since those would try to re-lock the same shard.
 //
 // Example: An element increasing function
 //
-//    m.ExecuteFunc(func() error {
-//        if v, ok := m.Map[i]; ok {
-//            m.Map[i] = v.(int) + 1
+//    err := m.ExecuteFuncForKey(i, func(get func() (interface{}, bool), set func(interface{})) error {
+//        if v, ok := get(); ok {
+//            set(v.(int) + 1)
 //        } else {
-//            m.Map[i] = int(1)
+//            set(1)
 //        }
 //        return nil
 //    })
-func (this *ParallelMap) ExecuteFunc(f func() error) {
-	this.wg.Add(1)
+func (this *ParallelMap) ExecuteFuncForKey(key interface{}, f func(get func() (interface{}, bool), set func(interface{})) error) error {
+	s := this.parallelMapBackend.shardFor(key)
+	s.Lock()
+	defer s.Unlock()
 
-	c := make(chan bool)
-	this.Op <- func() error {
-		err := f()
-
-		c <- true
-		this.wg.Done()
-		return err
+	get := func() (interface{}, bool) {
+		value, ok := s.m[key]
+		return value, ok
+	}
+	set := func(value interface{}) {
+		s.m[key] = value
 	}
-	<-c
+
+	return f(get, set)
 }
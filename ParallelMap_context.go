@@ -0,0 +1,52 @@
+// Copyright 2014 Wei Shen (shenwei356@gmail.com). All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+//
+// Context-aware variants of ParallelMap's core operations: they check
+// ctx before doing any work and return ctx.Err() instead of blocking
+// or proceeding once the caller has given up.
+package pmap
+
+import "context"
+
+// GetContext is Get, but returns ctx.Err() without touching the map
+// if ctx is already done.
+func (this *ParallelMap) GetContext(ctx context.Context, key interface{}) (interface{}, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	value, ok := this.Get(key)
+	return value, ok, nil
+}
+
+// SetContext is Set, but returns ctx.Err() without touching the map
+// if ctx is already done.
+func (this *ParallelMap) SetContext(ctx context.Context, key, value interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	this.Set(key, value)
+	return nil
+}
+
+// UpdateContext is Update, but returns ctx.Err() without touching the
+// map if ctx is already done.
+func (this *ParallelMap) UpdateContext(ctx context.Context, key, value interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	this.Update(key, value)
+	return nil
+}
+
+// ExecuteFuncContext is ExecuteFunc, but returns ctx.Err() without
+// running f if ctx is already done. The same restriction as
+// ExecuteFunc applies: f must not call Get, Set, Update, or any other
+// ParallelMap method, or it will deadlock on the shard locks
+// ExecuteFunc already holds.
+func (this *ParallelMap) ExecuteFuncContext(ctx context.Context, f func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return this.ExecuteFunc(f)
+}
@@ -4,10 +4,13 @@
 package pmap
 
 import (
+	"errors"
 	"fmt"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestParallelMap(t *testing.T) {
@@ -50,14 +53,235 @@ func TestParallelMap(t *testing.T) {
 	m.Stop()
 
 	// check length of map
-	if len(m.Map) != n {
+	if m.Len() != n {
 		t.Error("length error")
 	}
 
 	// check values
-	for _, v := range m.Map {
+	for j := 0; j < n; j++ {
+		v, ok := m.Get(j)
+		if !ok {
+			t.Error(fmt.Sprintf("missing key: %d", j))
+		}
 		if v.(int) != int(N) {
 			t.Error(fmt.Sprintf("value error: %d != %d", v.(int), int(N)))
 		}
 	}
 }
+
+func TestParallelMapAtomicOps(t *testing.T) {
+	m := NewParallelMap()
+
+	// LoadOrStore
+	actual, loaded := m.LoadOrStore("age", 26)
+	if loaded || actual.(int) != 26 {
+		t.Error("LoadOrStore should have stored a fresh value")
+	}
+	actual, loaded = m.LoadOrStore("age", 30)
+	if !loaded || actual.(int) != 26 {
+		t.Error("LoadOrStore should have loaded the existing value")
+	}
+
+	// CompareAndSwap
+	if !m.CompareAndSwap("age", 26, 27) {
+		t.Error("CompareAndSwap should have succeeded")
+	}
+	if m.CompareAndSwap("age", 26, 28) {
+		t.Error("CompareAndSwap should have failed on stale old value")
+	}
+
+	// CompareAndDelete
+	if m.CompareAndDelete("age", 26) {
+		t.Error("CompareAndDelete should have failed on stale old value")
+	}
+	if !m.CompareAndDelete("age", 27) {
+		t.Error("CompareAndDelete should have succeeded")
+	}
+	if _, ok := m.Get("age"); ok {
+		t.Error("age should have been deleted")
+	}
+
+	// LoadAndDelete
+	m.Set("name", "pmap")
+	value, loaded := m.LoadAndDelete("name")
+	if !loaded || value.(string) != "pmap" {
+		t.Error("LoadAndDelete should have returned the stored value")
+	}
+	if _, ok := m.Get("name"); ok {
+		t.Error("name should have been deleted")
+	}
+
+	// Range
+	m.Set("a", 1)
+	m.Set("b", 2)
+	sum := 0
+	m.Range(func(key, value interface{}) bool {
+		sum += value.(int)
+		return true
+	})
+	if sum != 3 {
+		t.Error("Range should have visited every key")
+	}
+}
+
+// TestParallelMapEqualFuncType covers assigning a variable of the
+// named EqualFunc type to ParallelMap's (promoted) EqualFunc field.
+func TestParallelMapEqualFuncType(t *testing.T) {
+	m := NewParallelMap()
+
+	var eq EqualFunc = func(a, b interface{}) bool {
+		return a.(string) == b.(string)
+	}
+	m.EqualFunc = eq
+
+	m.Set("name", "pmap")
+	if !m.CompareAndSwap("name", "pmap", "pmap2") {
+		t.Error("CompareAndSwap should have succeeded with the custom EqualFunc")
+	}
+}
+
+func TestParallelMapGetOrCompute(t *testing.T) {
+	m := NewParallelMap()
+
+	var calls int32
+	loader := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return 42, nil
+	}
+
+	// N concurrent callers asking for the same missing key should
+	// only run the loader once.
+	N := runtime.NumCPU() * 10
+	var wg sync.WaitGroup
+	for i := 0; i < N; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err, _ := m.GetOrCompute("answer", loader)
+			if err != nil || value.(int) != 42 {
+				t.Error("GetOrCompute returned an unexpected result")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Error("loader should have run exactly once")
+	}
+
+	// A second call should hit the cached value without running the
+	// loader again.
+	value, err, shared := m.GetOrCompute("answer", loader)
+	if err != nil || value.(int) != 42 || shared {
+		t.Error("GetOrCompute should have returned the cached value")
+	}
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Error("loader should not run again once the value is cached")
+	}
+
+	// Forget should make the loader run again.
+	m.Forget("answer")
+	if _, err, _ = m.GetOrCompute("answer", loader); err != nil {
+		t.Error("GetOrCompute after Forget returned an error")
+	}
+	if atomic.LoadInt32(&calls) != 2 {
+		t.Error("loader should run again after Forget")
+	}
+}
+
+// TestParallelMapForgetDuringInFlightCompute covers Forget racing with
+// a GetOrCompute call that is still running fn: the in-flight call
+// must not repopulate the map with its result once it finishes.
+func TestParallelMapForgetDuringInFlightCompute(t *testing.T) {
+	m := NewParallelMap()
+
+	running := make(chan struct{})
+	release := make(chan struct{})
+	loader := func() (interface{}, error) {
+		close(running)
+		<-release
+		return 42, nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		m.GetOrCompute("answer", loader)
+		close(done)
+	}()
+
+	<-running
+	m.Forget("answer")
+	close(release)
+	<-done
+
+	if _, ok := m.Get("answer"); ok {
+		t.Error("Forget during an in-flight GetOrCompute should prevent the result from being cached")
+	}
+}
+
+// runWithTimeout runs f in a goroutine and fails t if f has not
+// returned within d, which is how a self-deadlocking ExecuteFunc body
+// would otherwise hang the test suite forever.
+func runWithTimeout(t *testing.T, d time.Duration, f func()) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(d):
+		t.Fatal("timed out, likely deadlocked")
+	}
+}
+
+func TestParallelMapExecuteFunc(t *testing.T) {
+	m := NewParallelMap()
+
+	var ranExclusively bool
+	runWithTimeout(t, 3*time.Second, func() {
+		err := m.ExecuteFunc(func() error {
+			ranExclusively = true
+			return nil
+		})
+		if err != nil {
+			t.Error("ExecuteFunc returned an unexpected error")
+		}
+	})
+	if !ranExclusively {
+		t.Error("ExecuteFunc should have run f")
+	}
+
+	wantErr := errors.New("boom")
+	runWithTimeout(t, 3*time.Second, func() {
+		if err := m.ExecuteFunc(func() error { return wantErr }); err != wantErr {
+			t.Error("ExecuteFunc should have returned f's error")
+		}
+	})
+}
+
+func TestParallelMapExecuteFuncForKey(t *testing.T) {
+	m := NewParallelMap()
+
+	runWithTimeout(t, 3*time.Second, func() {
+		err := m.ExecuteFuncForKey("i", func(get func() (interface{}, bool), set func(interface{})) error {
+			if v, ok := get(); ok {
+				set(v.(int) + 1)
+			} else {
+				set(1)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Error("ExecuteFuncForKey returned an unexpected error")
+		}
+	})
+
+	v, ok := m.Get("i")
+	if !ok || v.(int) != 1 {
+		t.Error("ExecuteFuncForKey should have stored the incremented value")
+	}
+}
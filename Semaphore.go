@@ -0,0 +1,125 @@
+// Copyright 2014 Wei Shen (shenwei356@gmail.com). All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+//
+// Semaphore is a weighted semaphore in the style of
+// golang.org/x/sync/semaphore, used by NewParallelMapWithWorkers to
+// bound how many operations may run against the map at once.
+package pmap
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// Semaphore is a weighted semaphore that allows up to size units of
+// concurrency. The zero value is not usable; create one with
+// NewSemaphore.
+type Semaphore struct {
+	size    int64
+	cur     int64
+	mu      sync.Mutex
+	waiters list.List
+}
+
+type semaphoreWaiter struct {
+	n     int64
+	ready chan struct{}
+}
+
+// NewSemaphore returns a Semaphore that allows up to size units of
+// concurrency.
+func NewSemaphore(size int64) *Semaphore {
+	return &Semaphore{size: size}
+}
+
+// Acquire blocks until n units of capacity are available or ctx is
+// done, in which case it returns ctx.Err().
+func (this *Semaphore) Acquire(ctx context.Context, n int64) error {
+	this.mu.Lock()
+	if this.size-this.cur >= n && this.waiters.Len() == 0 {
+		this.cur += n
+		this.mu.Unlock()
+		return nil
+	}
+
+	if n > this.size {
+		this.mu.Unlock()
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	ready := make(chan struct{})
+	elem := this.waiters.PushBack(semaphoreWaiter{n: n, ready: ready})
+	this.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		err := ctx.Err()
+		this.mu.Lock()
+		select {
+		case <-ready:
+			// Acquired the semaphore after cancellation was
+			// observed but before we took the lock; give it back.
+			this.release(n)
+		default:
+			isFront := this.waiters.Front() == elem
+			this.waiters.Remove(elem)
+			if isFront {
+				this.notifyWaiters()
+			}
+		}
+		this.mu.Unlock()
+		return err
+	case <-ready:
+		return nil
+	}
+}
+
+// TryAcquire acquires n units of capacity without blocking, reporting
+// whether it succeeded.
+func (this *Semaphore) TryAcquire(n int64) bool {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	success := this.size-this.cur >= n && this.waiters.Len() == 0
+	if success {
+		this.cur += n
+	}
+	return success
+}
+
+// Release returns n units of capacity. It panics if more capacity is
+// released than was ever acquired.
+func (this *Semaphore) Release(n int64) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.release(n)
+}
+
+func (this *Semaphore) release(n int64) {
+	this.cur -= n
+	if this.cur < 0 {
+		panic("pmap: semaphore released more than held")
+	}
+	this.notifyWaiters()
+}
+
+func (this *Semaphore) notifyWaiters() {
+	for {
+		front := this.waiters.Front()
+		if front == nil {
+			break
+		}
+
+		w := front.Value.(semaphoreWaiter)
+		if this.size-this.cur < w.n {
+			break
+		}
+
+		this.cur += w.n
+		this.waiters.Remove(front)
+		close(w.ready)
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2014 Wei Shen (shenwei356@gmail.com). All rights reserved.
+// Use of this source code is governed by a MIT-license
+// that can be found in the LICENSE file.
+package pmap
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSemaphore(t *testing.T) {
+	sem := NewSemaphore(2)
+
+	if !sem.TryAcquire(2) {
+		t.Fatal("TryAcquire should have succeeded with full capacity free")
+	}
+	if sem.TryAcquire(1) {
+		t.Fatal("TryAcquire should have failed once capacity is exhausted")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := sem.Acquire(ctx, 1); err == nil {
+		t.Fatal("Acquire should have timed out while capacity is exhausted")
+	}
+
+	sem.Release(2)
+	if !sem.TryAcquire(2) {
+		t.Fatal("TryAcquire should have succeeded after Release")
+	}
+	sem.Release(2)
+}
+
+func TestParallelMapWithWorkers(t *testing.T) {
+	m := NewParallelMapWithWorkers(2)
+
+	m.Set("a", 1)
+	if v, ok := m.Get("a"); !ok || v.(int) != 1 {
+		t.Error("Get/Set should work the same in worker-pool mode")
+	}
+
+	if !m.TryAcquire(2) {
+		t.Fatal("TryAcquire should succeed with no operations in flight")
+	}
+	m.Release(2)
+}
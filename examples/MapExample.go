@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/shenwei356/pmap"
+)
+
+func main() {
+
+	// constructor, with typed keys and values
+	m := pmap.NewMap[string, int]()
+
+	// Set
+	m.Set("age", 26)
+
+	// Get
+	if age, ok := m.Get("age"); ok {
+		fmt.Printf("age: %d\n", age)
+	}
+
+	// Update
+	//
+	// Until an UpdateValueFunc is specified, Update behaves like Set.
+	m.SetUpdateValueFunc(func(oldValue, newValue int) int {
+		return oldValue + newValue
+	})
+
+	m.Update("age", 1)
+	if age, ok := m.Get("age"); ok {
+		fmt.Printf("age: %d\n", age)
+	}
+}
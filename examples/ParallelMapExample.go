@@ -49,18 +49,17 @@ func main() {
 	m.Stop()
 
 	// do something else
-	length := len(m.Map)
+	length := m.Len()
 	fmt.Printf("%d elements in map\n", length)
 
-	keys := make([]int, length)
-	i := 0
-	for k, _ := range m.Map {
-		keys[i] = k.(int)
-		i++
+	keys := make([]int, n)
+	for k := 0; k < n; k++ {
+		keys[k] = k
 	}
 	sort.Ints(keys)
 
 	for _, k := range keys {
-		fmt.Printf("%d => %d\n", k, m.Map[k])
+		v, _ := m.Get(k)
+		fmt.Printf("%d => %d\n", k, v)
 	}
 }